@@ -0,0 +1,65 @@
+package persistimer
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec 负责把上层业务payload编解码成字节流，用于 AddTimerPayload 把
+// payload内联编码进ZSET的member里，见该方法的文档.
+type Codec interface {
+	Name() string
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte, v interface{}) error
+}
+
+// JSONCodec 基于 encoding/json 的编解码器，是 Manager 的默认Codec.
+type JSONCodec struct{}
+
+// Name 实现 Codec.
+func (JSONCodec) Name() string { return "json" }
+
+// Encode 实现 Codec.
+func (JSONCodec) Encode(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+// Decode 实现 Codec.
+func (JSONCodec) Decode(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// MsgpackCodec 基于 msgpack 的编解码器，编码后体积通常比JSON更小.
+type MsgpackCodec struct{}
+
+// Name 实现 Codec.
+func (MsgpackCodec) Name() string { return "msgpack" }
+
+// Encode 实现 Codec.
+func (MsgpackCodec) Encode(v interface{}) ([]byte, error) { return msgpack.Marshal(v) }
+
+// Decode 实现 Codec.
+func (MsgpackCodec) Decode(data []byte, v interface{}) error { return msgpack.Unmarshal(data, v) }
+
+// ProtoCodec 基于protobuf的编解码器，payload必须实现 proto.Message.
+type ProtoCodec struct{}
+
+// Name 实现 Codec.
+func (ProtoCodec) Name() string { return "protobuf" }
+
+// Encode 实现 Codec.
+func (ProtoCodec) Encode(v interface{}) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec: %T does not implement proto.Message", v)
+	}
+	return proto.Marshal(msg)
+}
+
+// Decode 实现 Codec.
+func (ProtoCodec) Decode(data []byte, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec: %T does not implement proto.Message", v)
+	}
+	return proto.Unmarshal(data, msg)
+}
@@ -0,0 +1,104 @@
+package persistimer
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+)
+
+// Schedule 描述一个定时器的重复规则：要么是固定间隔 Interval，要么是
+// cron表达式 Cron（标准5字段格式，语法与 robfig/cron 一致），二者选一.
+type Schedule struct {
+	Interval time.Duration `json:"interval,omitempty"`
+	Cron     string        `json:"cron,omitempty"`
+}
+
+// Next 计算 after 之后的下一次触发时间.
+func (s Schedule) Next(after time.Time) (time.Time, error) {
+	if s.Interval > 0 {
+		return after.Add(s.Interval), nil
+	}
+	if s.Cron != "" {
+		sched, err := cron.ParseStandard(s.Cron)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid cron spec %q: %s", s.Cron, err)
+		}
+		return sched.Next(after), nil
+	}
+	return time.Time{}, fmt.Errorf("empty schedule: must set Interval or Cron")
+}
+
+// AddCron 增加一个循环定时器：首次按 spec 计算出的下一次时间触发，每次
+// 触发后 Manager 会在同一个pipeline里把 Ctx 和下一次触发时间重新写回，
+// 从而让循环持续下去，进程重启后从 Redis 里的调度状态继续，不会重复/漏触发.
+func (mgr *Manager) AddCron(id, ctx string, spec Schedule) error {
+	if err := validateTimerID(id); err != nil {
+		return err
+	}
+
+	first, err := spec.Next(time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to compute first deadline: %s", err)
+	}
+
+	shard := mgr.shardFor(id)
+
+	schedRaw, err := json.Marshal(spec)
+	if err != nil {
+		return fmt.Errorf("failed to encode schedule: %s", err)
+	}
+
+	pipe := mgr.redis.Pipeline()
+	defer pipe.Close()
+
+	pipe.Set(mgr.ContextKey(shard, id), ctx, 0)
+	pipe.Set(mgr.scheduleKey(shard, id), schedRaw, 0)
+	pipe.ZAdd(mgr.ShardKey(shard), redis.Z{Member: id, Score: float64(first.Unix())})
+	if _, err := pipe.Exec(); err != nil {
+		return fmt.Errorf("failed to add cron timer: %s", err)
+	}
+
+	return nil
+}
+
+// scheduleKey 循环定时器的调度规则存储键名.
+func (mgr *Manager) scheduleKey(shard int, id string) string {
+	return fmt.Sprintf("%s_sched_%s", mgr.ShardKey(shard), id)
+}
+
+// getSchedule 读取定时器对应的调度规则，不存在说明是普通的一次性定时器.
+func (mgr *Manager) getSchedule(shard int, id string) (Schedule, bool) {
+	raw, err := mgr.redis.Get(mgr.scheduleKey(shard, id)).Result()
+	if err != nil {
+		if err != redis.Nil {
+			zap.L().Warn(fmt.Sprintf("failed to read schedule %s: %s", id, err))
+		}
+		return Schedule{}, false
+	}
+
+	var spec Schedule
+	if err := json.Unmarshal([]byte(raw), &spec); err != nil {
+		zap.L().Warn(fmt.Sprintf("failed to decode schedule %s: %s", id, err))
+		return Schedule{}, false
+	}
+	return spec, true
+}
+
+// rescheduleRecurring 在单个pipeline里把循环定时器的Ctx和下一次触发时间
+// 重新写回ZSET，必须先于本次触发的通知投递执行，即便进程在投递通知前
+// 崩溃，下一次触发也已经落盘，不会丢失这个循环.
+func (mgr *Manager) rescheduleRecurring(shard int, id string, ctxRaw []byte, next time.Time) error {
+	pipe := mgr.redis.Pipeline()
+	defer pipe.Close()
+
+	pipe.Set(mgr.ContextKey(shard, id), ctxRaw, 0)
+	pipe.ZAdd(mgr.ShardKey(shard), redis.Z{Member: id, Score: float64(next.Unix())})
+	if _, err := pipe.Exec(); err != nil {
+		return fmt.Errorf("failed to reschedule recurring timer %s: %s", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package persistimer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func Test_cron_recurring_survivesRebalance(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr:         "127.0.0.1:6379",
+		Password:     "",
+		ReadTimeout:  1 * time.Second,
+		WriteTimeout: 1 * time.Second,
+		DialTimeout:  1 * time.Second,
+		PoolSize:     16,
+		MaxRetries:   3,
+	})
+
+	mgr, err := NewManager("timer_cron_rebalance", 128, client, 2)
+	if err != nil {
+		t.Fatalf("failed to new timer manager: %s", err)
+	}
+
+	if err := mgr.AddCron("cron-rebalance-1", "ctx-cron-rebalance", Schedule{Interval: time.Second}); err != nil {
+		t.Fatalf("failed to add cron timer: %s", err)
+	}
+
+	// migrateShard必须把scheduleKey也搬到新分片，否则下一次触发时
+	// getSchedule(newShard, id)会找不到规则，循环定时器退化成一次性的.
+	if err := mgr.Rebalance(4); err != nil {
+		t.Fatalf("failed to rebalance: %s", err)
+	}
+
+	ch := mgr.GetNotifys()
+	for i := 0; i < 2; i++ {
+		select {
+		case timer := <-ch:
+			if timer.ID != "cron-rebalance-1" || timer.Ctx != "ctx-cron-rebalance" {
+				t.Fatalf("wrong cron timer fired: %+v", timer)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("cron timer did not recur after rebalance, stuck after %d firings", i)
+		}
+	}
+}
+
+func Test_cron_recurring(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr:         "127.0.0.1:6379",
+		Password:     "",
+		ReadTimeout:  1 * time.Second,
+		WriteTimeout: 1 * time.Second,
+		DialTimeout:  1 * time.Second,
+		PoolSize:     16,
+		MaxRetries:   3,
+	})
+
+	mgr, err := NewManager("timer_cron", 128, client, 2)
+	if err != nil {
+		t.Fatalf("failed to new timer manager: %s", err)
+	}
+
+	if err := mgr.AddCron("cron-1", "ctx-cron", Schedule{Interval: time.Second}); err != nil {
+		t.Fatalf("failed to add cron timer: %s", err)
+	}
+
+	ch := mgr.GetNotifys()
+	for i := 0; i < 2; i++ {
+		select {
+		case timer := <-ch:
+			if timer.ID != "cron-1" || timer.Ctx != "ctx-cron" {
+				t.Fatalf("wrong cron timer fired: %+v", timer)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("cron timer did not recur, stuck after %d firings", i)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package persistimer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"go.uber.org/zap"
+)
+
+// ElectionBackend 分布式leader选举后端：当多个进程用同一个 name 启动
+// Manager 时，只有选举出的leader才会真正消费定时器，避免多副本重复触发.
+type ElectionBackend interface {
+	// Campaign 参与选举并阻塞，ctx被取消时返回（实现也可能在其它情况下
+	// 提前返回，见各实现的文档）；当选或失去leader身份的变化通过 Leader()
+	// 返回的channel通知.
+	Campaign(ctx context.Context)
+	// IsLeader 返回当前是否为leader.
+	IsLeader() bool
+	// Leader 返回leader状态变化通知：true表示当选，false表示失去leader身份
+	// （续约失败、主动让位等）.
+	Leader() <-chan bool
+	// Close 关闭选举后端，放弃leader身份并释放资源.
+	Close() error
+}
+
+// RedisElectionBackend 基于Redis SETNX+租约实现的选举后端：复用 Manager
+// 已经持有的 *redis.Client，不引入额外的外部依赖，代价是依赖单个Redis
+// 实例的可用性，不是跨多个独立Redis节点的完整Redlock算法.
+type RedisElectionBackend struct {
+	key      string // 选举锁的key
+	id       string // 本进程的身份标识，用于确认续约时锁仍是自己持有的
+	redis    *redis.Client
+	lease    time.Duration // 租约时长
+	leaderCh chan bool
+
+	mu       sync.Mutex
+	isLeader bool
+	closed   chan struct{}
+}
+
+// NewRedisElectionBackend 创建基于Redis的选举后端，id 是本进程的唯一标识
+// （如 hostname+pid），lease 是锁的租约时长，续约周期为 lease/2.
+func NewRedisElectionBackend(name, id string, redis *redis.Client, lease time.Duration) *RedisElectionBackend {
+	if lease <= 0 {
+		lease = 10 * time.Second
+	}
+	return &RedisElectionBackend{
+		key:      fmt.Sprintf("%s_election", name),
+		id:       id,
+		redis:    redis,
+		lease:    lease,
+		leaderCh: make(chan bool, 1),
+		closed:   make(chan struct{}),
+	}
+}
+
+// Campaign 周期性地尝试SETNX抢锁、或者作为持有者续约，直到ctx被取消.
+func (b *RedisElectionBackend) Campaign(ctx context.Context) {
+	ticker := time.NewTicker(b.lease / 2)
+	defer ticker.Stop()
+
+	for {
+		b.tryAcquireOrRenew()
+
+		select {
+		case <-ctx.Done():
+			b.stepDown()
+			return
+		case <-b.closed:
+			b.stepDown()
+			return
+		case <-ticker.C:
+			// 继续下一轮抢锁/续约
+		}
+	}
+}
+
+func (b *RedisElectionBackend) tryAcquireOrRenew() {
+	ok, err := b.redis.SetNX(b.key, b.id, b.lease).Result()
+	if err != nil {
+		zap.L().Warn(fmt.Sprintf("election: failed to campaign %s: %s", b.key, err))
+		b.setLeader(false)
+		return
+	}
+	if ok {
+		b.setLeader(true)
+		return
+	}
+
+	// 没抢到锁，尝试续约：用Lua脚本把"校验锁仍是自己持有"和"续期"合并成一次
+	// 原子操作，避免GET和EXPIRE之间的窗口期里锁被别的进程抢走、这里却盲目
+	// 续期对方的锁导致双主.
+	renewed, err := renewScript.Run(b.redis, []string{b.key}, b.id, int64(b.lease/time.Millisecond)).Result()
+	if err != nil {
+		zap.L().Warn(fmt.Sprintf("election: failed to renew %s: %s", b.key, err))
+		b.setLeader(false)
+		return
+	}
+	b.setLeader(renewed.(int64) == 1)
+}
+
+// renewScript 原子地校验锁仍由ARGV[1]持有后续期ARGV[2]毫秒，返回1表示续约成功.
+var renewScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+else
+	return 0
+end
+`)
+
+func (b *RedisElectionBackend) stepDown() {
+	b.mu.Lock()
+	wasLeader := b.isLeader
+	b.mu.Unlock()
+
+	if wasLeader {
+		// 仅在仍持有锁时删除，避免误删其它进程刚抢到的锁
+		script := redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`)
+		if err := script.Run(b.redis, []string{b.key}, b.id).Err(); err != nil {
+			zap.L().Warn(fmt.Sprintf("election: failed to release lock %s: %s", b.key, err))
+		}
+	}
+	b.setLeader(false)
+}
+
+func (b *RedisElectionBackend) setLeader(leader bool) {
+	b.mu.Lock()
+	changed := b.isLeader != leader
+	b.isLeader = leader
+	b.mu.Unlock()
+
+	if changed {
+		select {
+		case b.leaderCh <- leader:
+		default:
+			// 丢弃旧的未读状态，只保留最新的
+			select {
+			case <-b.leaderCh:
+			default:
+			}
+			b.leaderCh <- leader
+		}
+	}
+}
+
+// IsLeader 返回当前是否为leader.
+func (b *RedisElectionBackend) IsLeader() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.isLeader
+}
+
+// Leader 返回leader状态变化通知channel.
+func (b *RedisElectionBackend) Leader() <-chan bool {
+	return b.leaderCh
+}
+
+// Close 放弃leader身份并停止续约循环.
+func (b *RedisElectionBackend) Close() error {
+	close(b.closed)
+	return nil
+}
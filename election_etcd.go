@@ -0,0 +1,119 @@
+package persistimer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+	"go.uber.org/zap"
+)
+
+// EtcdElectionBackend 基于etcd concurrency包实现的选举后端：依赖etcd
+// lease的自动续约能力，相比 RedisElectionBackend 更适合已经部署了etcd
+// 的环境，避免自己实现续约和脑裂防护.
+type EtcdElectionBackend struct {
+	name     string
+	client   *clientv3.Client
+	ttl      int // session租约秒数
+	leaderCh chan bool
+
+	mu       sync.Mutex
+	isLeader bool
+	session  *concurrency.Session
+	election *concurrency.Election
+}
+
+// NewEtcdElectionBackend 创建基于etcd的选举后端，ttlSeconds 是session的
+// 租约时长（秒），client需调用方负责生命周期管理.
+func NewEtcdElectionBackend(name string, client *clientv3.Client, ttlSeconds int) *EtcdElectionBackend {
+	if ttlSeconds <= 0 {
+		ttlSeconds = 10
+	}
+	return &EtcdElectionBackend{
+		name:     fmt.Sprintf("/persistimer/election/%s", name),
+		client:   client,
+		ttl:      ttlSeconds,
+		leaderCh: make(chan bool, 1),
+	}
+}
+
+// Campaign 创建带自动续约的etcd session并参选，直到当选或ctx被取消；
+// 当选后阻塞监视session是否失效（租约过期），失效后通知失去leader身份.
+// 创建session失败、参选失败或session失效时直接返回，不会重新参选.
+func (b *EtcdElectionBackend) Campaign(ctx context.Context) {
+	session, err := concurrency.NewSession(b.client, concurrency.WithTTL(b.ttl), concurrency.WithContext(ctx))
+	if err != nil {
+		zap.L().Warn(fmt.Sprintf("election: failed to create etcd session: %s", err))
+		return
+	}
+	defer session.Close()
+
+	b.mu.Lock()
+	b.session = session
+	election := concurrency.NewElection(session, b.name)
+	b.election = election
+	b.mu.Unlock()
+
+	if err := election.Campaign(ctx, "leader"); err != nil {
+		if ctx.Err() == nil {
+			zap.L().Warn(fmt.Sprintf("election: campaign failed: %s", err))
+		}
+		return
+	}
+
+	b.setLeader(true)
+	defer b.setLeader(false)
+
+	select {
+	case <-ctx.Done():
+	case <-session.Done(): // 租约失效，说明失去leader身份（如网络分区）
+	}
+}
+
+func (b *EtcdElectionBackend) setLeader(leader bool) {
+	b.mu.Lock()
+	changed := b.isLeader != leader
+	b.isLeader = leader
+	b.mu.Unlock()
+
+	if changed {
+		select {
+		case b.leaderCh <- leader:
+		default:
+			select {
+			case <-b.leaderCh:
+			default:
+			}
+			b.leaderCh <- leader
+		}
+	}
+}
+
+// IsLeader 返回当前是否为leader.
+func (b *EtcdElectionBackend) IsLeader() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.isLeader
+}
+
+// Leader 返回leader状态变化通知channel.
+func (b *EtcdElectionBackend) Leader() <-chan bool {
+	return b.leaderCh
+}
+
+// Close 主动让位并关闭session.
+func (b *EtcdElectionBackend) Close() error {
+	b.mu.Lock()
+	election, session := b.election, b.session
+	b.mu.Unlock()
+
+	if election != nil && session != nil {
+		_ = election.Resign(context.Background())
+	}
+	if session != nil {
+		return session.Close()
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package persistimer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func Test_redisElectionBackend_failover(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr:         "127.0.0.1:6379",
+		Password:     "",
+		ReadTimeout:  1 * time.Second,
+		WriteTimeout: 1 * time.Second,
+		DialTimeout:  1 * time.Second,
+		PoolSize:     16,
+		MaxRetries:   3,
+	})
+
+	name := "election_failover"
+	a := NewRedisElectionBackend(name, "node-a", client, 200*time.Millisecond)
+	b := NewRedisElectionBackend(name, "node-b", client, 200*time.Millisecond)
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	ctxB, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+	go a.Campaign(ctxA)
+	go b.Campaign(ctxB)
+
+	waitLeader(t, a, true, 2*time.Second)
+	if b.IsLeader() {
+		t.Fatalf("node-b should not be leader while node-a holds the lock")
+	}
+
+	// node-a下线时会释放锁，node-b应当在下一轮抢锁时接管leader身份.
+	cancelA()
+	waitLeader(t, b, true, 2*time.Second)
+}
+
+func waitLeader(t *testing.T, b *RedisElectionBackend, want bool, timeout time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if b.IsLeader() == want {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for leader=%v", want)
+}
@@ -0,0 +1,123 @@
+package persistimer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	"go.uber.org/zap"
+)
+
+const memberSep = "|" // ZSET member里 id 和内联payload的分隔符，对所有Timer.ID全局保留
+
+// validateTimerID 校验定时器ID不包含 memberSep：background() 靠这个分隔符
+// 区分 AddTimerPayload 写入的内联payload定时器和 AddTimer/AddCron 写入的
+// 普通定时器，如果普通定时器的ID里也允许出现这个分隔符，一个形似
+// "tenant|QUJD"且"QUJD"恰好是合法base64的ID就会被误判成内联payload，导致
+// Ctx/HandlerName被错误地跳过。因此三个写入入口都必须校验，而不只是
+// AddTimerPayload.
+func validateTimerID(id string) error {
+	if strings.Contains(id, memberSep) {
+		return fmt.Errorf("timer id must not contain %q: %s", memberSep, id)
+	}
+	return nil
+}
+
+// SetCodec 设置 AddTimerPayload/GetTypedNotifys 使用的编解码器，默认是
+// JSONCodec{}.
+func (mgr *Manager) SetCodec(codec Codec) {
+	mgr.codec = codec
+}
+
+// AddTimerPayload 增加一个携带结构化payload的定时器：payload会被当前
+// Codec编码后，以 `id|base64(payload)` 的形式直接内联存进ZSET的member
+// 里，触发时BZPopMin的返回结果就带有全部数据，不用再像 AddTimer 那样
+// 额外GET一次ContextKey，也就不存在ContextKey的TTL在ZSET条目触发前先
+// 过期导致丢上下文的问题.
+func (mgr *Manager) AddTimerPayload(id string, payload interface{}, deadline time.Time) error {
+	if err := validateTimerID(id); err != nil {
+		return err
+	}
+
+	data, err := mgr.codec.Encode(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode timer payload: %s", err)
+	}
+	member := id + memberSep + base64.StdEncoding.EncodeToString(data)
+
+	shard := mgr.shardFor(id)
+
+	pipe := mgr.redis.Pipeline()
+	defer pipe.Close()
+
+	// 记录 id -> member 的映射，供 DelTimer 按id精确 ZRem.
+	pipe.HSet(mgr.memberIndexKey(shard), id, member)
+	pipe.ZAdd(mgr.ShardKey(shard), redis.Z{Member: member, Score: float64(deadline.Unix())})
+	if _, err := pipe.Exec(); err != nil {
+		return fmt.Errorf("failed to add timer payload: %s", err)
+	}
+
+	return nil
+}
+
+func (mgr *Manager) memberIndexKey(shard int) string {
+	return fmt.Sprintf("%s_members", mgr.ShardKey(shard))
+}
+
+// resolveMember 返回id在ZSET里实际使用的member：AddTimerPayload写入的
+// 定时器是 `id|base64(...)`，AddTimer/AddCron写入的定时器就是id本身.
+func (mgr *Manager) resolveMember(shard int, id string) string {
+	member, err := mgr.redis.HGet(mgr.memberIndexKey(shard), id).Result()
+	if err != nil {
+		return id
+	}
+	return member
+}
+
+// splitInlineMember 尝试把BZPopMin弹出的member解析成 AddTimerPayload
+// 写入的 `id|base64(payload)` 格式；ok为false说明这是普通定时器.
+func splitInlineMember(member string) (id string, payload []byte, ok bool) {
+	idx := strings.Index(member, memberSep)
+	if idx < 0 {
+		return "", nil, false
+	}
+	data, err := base64.StdEncoding.DecodeString(member[idx+len(memberSep):])
+	if err != nil {
+		return "", nil, false
+	}
+	return member[:idx], data, true
+}
+
+// TypedTimer 是 GetTypedNotifys 解码后的定时器事件.
+type TypedTimer[T any] struct {
+	ID       string
+	Value    T
+	Deadline time.Time
+}
+
+// GetTypedNotifys 包装 mgr.GetNotifys()，用 mgr 当前的Codec把
+// Timer.Payload解码成T后投递；Go不支持泛型方法，所以实现成一个接受
+// *Manager的包级函数。只适合携带内联payload（AddTimerPayload写入）的
+// 定时器，没有Payload的事件会被跳过.
+func GetTypedNotifys[T any](mgr *Manager) <-chan TypedTimer[T] {
+	out := make(chan TypedTimer[T], cap(mgr.notifys))
+
+	go func() {
+		defer close(out)
+		for t := range mgr.notifys {
+			if len(t.Payload) == 0 {
+				continue
+			}
+			var v T
+			if err := mgr.codec.Decode(t.Payload, &v); err != nil {
+				zap.L().Warn(fmt.Sprintf("failed to decode typed payload for %s: %s", t.ID, err))
+				continue
+			}
+			out <- TypedTimer[T]{ID: t.ID, Value: v, Deadline: t.Deadline}
+		}
+	}()
+
+	return out
+}
@@ -0,0 +1,84 @@
+package persistimer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+type payloadTestOrder struct {
+	OrderID string `json:"order_id"`
+	Amount  int    `json:"amount"`
+}
+
+func Test_addTimerPayload_delAfterRebalance(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr:         "127.0.0.1:6379",
+		Password:     "",
+		ReadTimeout:  1 * time.Second,
+		WriteTimeout: 1 * time.Second,
+		DialTimeout:  1 * time.Second,
+		PoolSize:     16,
+		MaxRetries:   3,
+	})
+
+	mgr, err := NewManager("timer_payload_rebalance", 128, client, 2)
+	if err != nil {
+		t.Fatalf("failed to new timer manager: %s", err)
+	}
+
+	if err := mgr.AddTimerPayload("payload-rebalance-1", payloadTestOrder{OrderID: "order-2", Amount: 7}, time.Now().Add(5*time.Second)); err != nil {
+		t.Fatalf("failed to add timer payload: %s", err)
+	}
+
+	// migrateShard必须按真实id（而不是`id|base64(...)`整个member）计算目标
+	// 分片，并把memberIndexKey一起搬过去，否则DelTimer在新分片里查不到
+	// 映射，会退化成按id本身做无效ZRem，定时器实际上没被删掉.
+	if err := mgr.Rebalance(4); err != nil {
+		t.Fatalf("failed to rebalance: %s", err)
+	}
+
+	if err := mgr.DelTimer("payload-rebalance-1"); err != nil {
+		t.Fatalf("failed to del timer: %s", err)
+	}
+
+	select {
+	case timer := <-GetTypedNotifys[payloadTestOrder](mgr):
+		t.Fatalf("deleted timer still fired: %+v", timer)
+	case <-time.After(7 * time.Second):
+		// 预期：超过原定到期时间也不应该再触发
+	}
+}
+
+func Test_addTimerPayload_typedNotifys(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr:         "127.0.0.1:6379",
+		Password:     "",
+		ReadTimeout:  1 * time.Second,
+		WriteTimeout: 1 * time.Second,
+		DialTimeout:  1 * time.Second,
+		PoolSize:     16,
+		MaxRetries:   3,
+	})
+
+	mgr, err := NewManager("timer_payload", 128, client, 2)
+	if err != nil {
+		t.Fatalf("failed to new timer manager: %s", err)
+	}
+
+	order := payloadTestOrder{OrderID: "order-1", Amount: 42}
+	if err := mgr.AddTimerPayload("payload-1", order, time.Now()); err != nil {
+		t.Fatalf("failed to add timer payload: %s", err)
+	}
+
+	ch := GetTypedNotifys[payloadTestOrder](mgr)
+	select {
+	case timer := <-ch:
+		if timer.ID != "payload-1" || timer.Value != order {
+			t.Fatalf("wrong typed timer: %+v", timer)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("typed timer payload did not fire")
+	}
+}
@@ -0,0 +1,354 @@
+package persistimer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"go.uber.org/zap"
+)
+
+// HandlerFunc 是注册到 HandlerRegistry 中的回调函数，ctx 对应 Timer.Ctx.
+type HandlerFunc func(ctx string) error
+
+// Executor 决定回调函数以什么并发策略执行，RegisterHandler触发的回调都
+// 经由当前 Manager 设置的 Executor 提交执行.
+type Executor interface {
+	Submit(task func())
+}
+
+// GoroutinePerTaskExecutor 每个任务起一个新goroutine，无并发上限.
+type GoroutinePerTaskExecutor struct{}
+
+// Submit 实现 Executor.
+func (GoroutinePerTaskExecutor) Submit(task func()) {
+	go task()
+}
+
+// BoundedExecutor 用带缓冲的信号量限制同时执行的任务数.
+type BoundedExecutor struct {
+	sem chan struct{}
+}
+
+// NewBoundedExecutor 创建一个最多同时执行 n 个任务的Executor.
+func NewBoundedExecutor(n int) *BoundedExecutor {
+	if n <= 0 {
+		n = 1
+	}
+	return &BoundedExecutor{sem: make(chan struct{}, n)}
+}
+
+// Submit 实现 Executor，信号量满时阻塞到有空位为止.
+func (e *BoundedExecutor) Submit(task func()) {
+	e.sem <- struct{}{}
+	go func() {
+		defer func() { <-e.sem }()
+		task()
+	}()
+}
+
+// WorkerPoolExecutor 用固定数量的常驻worker goroutine消费任务队列.
+type WorkerPoolExecutor struct {
+	tasks chan func()
+}
+
+// NewWorkerPoolExecutor 创建 workers 个常驻worker、队列长度为 queue 的
+// WorkerPoolExecutor；队列满时 Submit 会阻塞.
+func NewWorkerPoolExecutor(workers, queue int) *WorkerPoolExecutor {
+	if workers <= 0 {
+		workers = 1
+	}
+	e := &WorkerPoolExecutor{tasks: make(chan func(), queue)}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for task := range e.tasks {
+				task()
+			}
+		}()
+	}
+	return e
+}
+
+// Submit 实现 Executor.
+func (e *WorkerPoolExecutor) Submit(task func()) {
+	e.tasks <- task
+}
+
+// DeadLetter 是最终失败、进入死信队列的定时器回调记录.
+type DeadLetter struct {
+	ID          string `json:"id"`
+	Ctx         string `json:"ctx"`
+	HandlerName string `json:"handler"`
+	Err         string `json:"err"`
+}
+
+type inflightMeta struct {
+	Ctx         string `json:"ctx"`
+	HandlerName string `json:"handler"`
+}
+
+// registry 承载 HandlerRegistry 相关的可选能力；未调用 RegisterHandler
+// 的 Manager 不受影响，行为和未引入注册表之前完全一致.
+type registry struct {
+	hmu      sync.RWMutex
+	handlers map[string]HandlerFunc
+
+	// cmu 保护 executor/maxAttempts/retryBackoff：SetExecutor/SetRetryPolicy
+	// 可能在 dispatch/invoke/reapExpiredOnce 等goroutine运行期间被调用.
+	cmu           sync.RWMutex
+	executor      Executor
+	maxAttempts   int
+	retryBackoff  func(attempt int) time.Duration
+	leaseDuration time.Duration
+
+	reaperStop chan struct{}
+	reaperOnce sync.Once
+}
+
+func newRegistry() *registry {
+	return &registry{
+		handlers:      make(map[string]HandlerFunc),
+		executor:      GoroutinePerTaskExecutor{},
+		maxAttempts:   3,
+		retryBackoff:  func(attempt int) time.Duration { return time.Duration(attempt+1) * time.Second },
+		leaseDuration: 30 * time.Second,
+		reaperStop:    make(chan struct{}),
+	}
+}
+
+// RegisterHandler 注册一个按名字分发的回调；定时器触发时若其 HandlerName
+// 命中这里注册的名字，Manager 会自动调用它。凡是 HandlerName 非空的定时器
+// 都不会投递到 GetNotifys() 返回的管道：没有注册同名回调时，这次调用按
+// 失败处理，同样走重试和死信队列.
+func (mgr *Manager) RegisterHandler(name string, fn HandlerFunc) {
+	mgr.registry.hmu.Lock()
+	mgr.registry.handlers[name] = fn
+	mgr.registry.hmu.Unlock()
+
+	mgr.registry.reaperOnce.Do(func() { go mgr.reapInflight() })
+}
+
+// SetExecutor 设置回调函数的执行策略，默认是 GoroutinePerTaskExecutor.
+func (mgr *Manager) SetExecutor(executor Executor) {
+	mgr.registry.cmu.Lock()
+	mgr.registry.executor = executor
+	mgr.registry.cmu.Unlock()
+}
+
+// SetRetryPolicy 设置回调失败时的最大尝试次数（含首次）和退避策略.
+func (mgr *Manager) SetRetryPolicy(maxAttempts int, backoff func(attempt int) time.Duration) {
+	mgr.registry.cmu.Lock()
+	defer mgr.registry.cmu.Unlock()
+	if maxAttempts > 0 {
+		mgr.registry.maxAttempts = maxAttempts
+	}
+	if backoff != nil {
+		mgr.registry.retryBackoff = backoff
+	}
+}
+
+// getExecutor/getMaxAttempts/getRetryBackoff 加锁读取对应配置，供
+// dispatch/invoke 等并发goroutine使用.
+func (mgr *Manager) getExecutor() Executor {
+	mgr.registry.cmu.RLock()
+	defer mgr.registry.cmu.RUnlock()
+	return mgr.registry.executor
+}
+
+func (mgr *Manager) getMaxAttempts() int {
+	mgr.registry.cmu.RLock()
+	defer mgr.registry.cmu.RUnlock()
+	return mgr.registry.maxAttempts
+}
+
+func (mgr *Manager) getRetryBackoff() func(attempt int) time.Duration {
+	mgr.registry.cmu.RLock()
+	defer mgr.registry.cmu.RUnlock()
+	return mgr.registry.retryBackoff
+}
+
+func (mgr *Manager) getHandler(name string) (HandlerFunc, bool) {
+	mgr.registry.hmu.RLock()
+	defer mgr.registry.hmu.RUnlock()
+	fn, ok := mgr.registry.handlers[name]
+	return fn, ok
+}
+
+// dispatch 把命中 HandlerRegistry 的定时器标记为in-flight并提交执行，
+// 保证即便执行回调的进程崩溃，reapInflight 也能把它重新投递一次
+// （at-least-once）。shard 是这个定时器所在的分片，inflight/死信键都按
+// 分片存放，和 ContextKey/HandlerKey 等键一样避免单个全局key成为热点，
+// 也让pipeline里的key落在同一个hash tag下.
+func (mgr *Manager) dispatch(shard int, t *Timer) {
+	meta := inflightMeta{Ctx: t.Ctx, HandlerName: t.HandlerName}
+	raw, err := json.Marshal(meta)
+	if err != nil {
+		zap.L().Warn(fmt.Sprintf("failed to encode inflight meta %s: %s", t.ID, err))
+		return
+	}
+
+	lease := time.Now().Add(mgr.registry.leaseDuration)
+	pipe := mgr.redis.Pipeline()
+	pipe.ZAdd(mgr.inflightKey(shard), redis.Z{Member: t.ID, Score: float64(lease.Unix())})
+	pipe.HSet(mgr.inflightMetaKey(shard), t.ID, raw)
+	if _, err := pipe.Exec(); err != nil {
+		zap.L().Warn(fmt.Sprintf("failed to mark timer in-flight %s: %s", t.ID, err))
+	}
+
+	mgr.getExecutor().Submit(func() { mgr.invoke(shard, t.ID, t.Ctx, t.HandlerName, 0) })
+}
+
+// invoke 调用注册的回调，失败时按退避策略重试，耗尽次数后写入死信队列。
+// 重试不会阻塞在当前goroutine上等待退避时间，而是用 time.AfterFunc 延时后
+// 重新 Submit 给 Executor，否则 BoundedExecutor/WorkerPoolExecutor 的一个
+// 并发槽位会被一条失败定时器的整条退避链路占满，挤占其它定时器的执行.
+func (mgr *Manager) invoke(shard int, id, ctx, handlerName string, attempt int) {
+	handler, ok := mgr.getHandler(handlerName)
+
+	var err error
+	if !ok {
+		err = fmt.Errorf("no handler registered: %s", handlerName)
+	} else {
+		err = handler(ctx)
+	}
+
+	if err == nil {
+		mgr.completeInflight(shard, id)
+		return
+	}
+
+	if attempt+1 >= mgr.getMaxAttempts() {
+		mgr.sendToDeadLetter(shard, id, ctx, handlerName, err)
+		mgr.completeInflight(shard, id)
+		return
+	}
+
+	backoff := mgr.getRetryBackoff()(attempt)
+	time.AfterFunc(backoff, func() {
+		mgr.getExecutor().Submit(func() { mgr.invoke(shard, id, ctx, handlerName, attempt+1) })
+	})
+}
+
+func (mgr *Manager) completeInflight(shard int, id string) {
+	pipe := mgr.redis.Pipeline()
+	pipe.ZRem(mgr.inflightKey(shard), id)
+	pipe.HDel(mgr.inflightMetaKey(shard), id)
+	if _, err := pipe.Exec(); err != nil {
+		zap.L().Warn(fmt.Sprintf("failed to complete in-flight timer %s: %s", id, err))
+	}
+}
+
+func (mgr *Manager) sendToDeadLetter(shard int, id, ctx, handlerName string, cause error) {
+	dl := DeadLetter{ID: id, Ctx: ctx, HandlerName: handlerName, Err: cause.Error()}
+	raw, err := json.Marshal(dl)
+	if err != nil {
+		zap.L().Warn(fmt.Sprintf("failed to encode dead letter %s: %s", id, err))
+		return
+	}
+	if err := mgr.redis.LPush(mgr.deadLetterKey(shard), raw).Err(); err != nil {
+		zap.L().Warn(fmt.Sprintf("failed to push dead letter %s: %s", id, err))
+	}
+}
+
+// DeadLetters 读取最近 limit 条最终失败的回调记录，用于人工排查或补偿；
+// 死信队列按分片存放，这里按分片顺序聚合直到凑够 limit 条.
+func (mgr *Manager) DeadLetters(limit int64) ([]DeadLetter, error) {
+	mgr.mu.RLock()
+	shardCount := mgr.shardCount
+	mgr.mu.RUnlock()
+
+	letters := make([]DeadLetter, 0, limit)
+	for shard := 0; shard < shardCount && int64(len(letters)) < limit; shard++ {
+		raws, err := mgr.redis.LRange(mgr.deadLetterKey(shard), 0, limit-1).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read dead letters: %s", err)
+		}
+		for _, raw := range raws {
+			var dl DeadLetter
+			if err := json.Unmarshal([]byte(raw), &dl); err != nil {
+				zap.L().Warn(fmt.Sprintf("failed to decode dead letter: %s", err))
+				continue
+			}
+			letters = append(letters, dl)
+			if int64(len(letters)) >= limit {
+				break
+			}
+		}
+	}
+	return letters, nil
+}
+
+// reapInflight 周期性地把租约已过期、但仍未完成的in-flight定时器重新
+// AddTimer回去等待被消费，用于兜底执行回调的进程在完成前崩溃的情况；
+// inflight是按分片存放的，每轮要挨个分片扫描.
+func (mgr *Manager) reapInflight() {
+	ticker := time.NewTicker(mgr.registry.leaseDuration / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-mgr.registry.reaperStop:
+			return
+		case <-ticker.C:
+			mgr.mu.RLock()
+			shardCount := mgr.shardCount
+			mgr.mu.RUnlock()
+			for shard := 0; shard < shardCount; shard++ {
+				mgr.reapExpiredOnce(shard)
+			}
+		}
+	}
+}
+
+func (mgr *Manager) reapExpiredOnce(shard int) {
+	ids, err := mgr.redis.ZRangeByScore(mgr.inflightKey(shard), redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprintf("%d", time.Now().Unix()),
+	}).Result()
+	if err != nil {
+		if err != redis.Nil {
+			zap.L().Warn(fmt.Sprintf("failed to scan in-flight timers: %s", err))
+		}
+		return
+	}
+
+	for _, id := range ids {
+		raw, err := mgr.redis.HGet(mgr.inflightMetaKey(shard), id).Result()
+		if err != nil {
+			if err != redis.Nil {
+				zap.L().Warn(fmt.Sprintf("failed to read in-flight meta %s: %s", id, err))
+			}
+			mgr.redis.ZRem(mgr.inflightKey(shard), id)
+			continue
+		}
+
+		var meta inflightMeta
+		if err := json.Unmarshal([]byte(raw), &meta); err != nil {
+			zap.L().Warn(fmt.Sprintf("failed to decode in-flight meta %s: %s", id, err))
+			mgr.completeInflight(shard, id)
+			continue
+		}
+
+		mgr.completeInflight(shard, id)
+		if err := mgr.AddTimer(&Timer{ID: id, Ctx: meta.Ctx, HandlerName: meta.HandlerName, Deadline: time.Now()}); err != nil {
+			zap.L().Warn(fmt.Sprintf("failed to requeue expired in-flight timer %s: %s", id, err))
+		}
+	}
+}
+
+// inflightKey/inflightMetaKey/deadLetterKey 都按分片存放，和 ContextKey 等
+// 键共用同一套 ShardKey 哈希标签，避免单个全局ZSET/list成为热点，也避免
+// dispatch 的pipeline里出现不在同一个hash tag下的key.
+func (mgr *Manager) inflightKey(shard int) string {
+	return fmt.Sprintf("%s_inflight", mgr.ShardKey(shard))
+}
+
+func (mgr *Manager) inflightMetaKey(shard int) string {
+	return fmt.Sprintf("%s_inflight_meta", mgr.ShardKey(shard))
+}
+
+func (mgr *Manager) deadLetterKey(shard int) string {
+	return fmt.Sprintf("%s_dlq", mgr.ShardKey(shard))
+}
@@ -0,0 +1,72 @@
+package persistimer
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func Test_handlerRegistry_retryAndDeadLetter(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr:         "127.0.0.1:6379",
+		Password:     "",
+		ReadTimeout:  1 * time.Second,
+		WriteTimeout: 1 * time.Second,
+		DialTimeout:  1 * time.Second,
+		PoolSize:     16,
+		MaxRetries:   3,
+	})
+
+	mgr, err := NewManager("timer_registry", 128, client, 2)
+	if err != nil {
+		t.Fatalf("failed to new timer manager: %s", err)
+	}
+
+	mgr.SetRetryPolicy(3, func(attempt int) time.Duration { return 10 * time.Millisecond })
+
+	attempts := make(chan int, 8)
+	n := 0
+	mgr.RegisterHandler("always-fails", func(ctx string) error {
+		n++
+		attempts <- n
+		return fmt.Errorf("boom: %s", ctx)
+	})
+
+	if err := mgr.AddTimer(&Timer{ID: "retry-1", Ctx: "ctx-retry", HandlerName: "always-fails", Deadline: time.Now()}); err != nil {
+		t.Fatalf("failed to add timer: %s", err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		select {
+		case got := <-attempts:
+			if got != i {
+				t.Fatalf("wrong attempt order: want %d, got %d", i, got)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("handler was not retried enough times, stuck after %d attempts", i-1)
+		}
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		letters, err := mgr.DeadLetters(10)
+		if err != nil {
+			t.Fatalf("failed to read dead letters: %s", err)
+		}
+		found := false
+		for _, dl := range letters {
+			if dl.ID == "retry-1" {
+				found = true
+			}
+		}
+		if found {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timer was not sent to dead letter queue after exhausting retries")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
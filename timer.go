@@ -1,7 +1,10 @@
 package persistimer
 
 import (
+	"context"
 	"fmt"
+	"hash/fnv"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis"
@@ -10,76 +13,202 @@ import (
 
 // Timer 定时器
 type Timer struct {
-	ID       string    // 定时器ID，需要保持唯一
-	Ctx      string    // 定时器上下文，用于存储上层业务数据
-	Deadline time.Time // 定时发生时间，只有一次
+	ID          string    // 定时器ID，需要保持唯一
+	Ctx         string    // 定时器上下文，用于存储上层业务数据
+	Deadline    time.Time // 定时发生时间，只有一次
+	HandlerName string    // 可选，命中 HandlerRegistry 中注册的回调名时由 Manager 自动执行，见 RegisterHandler
+	Payload     []byte    // 可选，AddTimerPayload 内联编码进ZSET member的结构化payload，配合 GetTypedNotifys 使用
 }
 
 // Manager 简单的不易失定时器管理器： 进程重启不丢失定时器
 // 缺点：
-//   1. 不能持久化回调函数
-//   2. 单个zset保持所有定时器，如果定时器过多，存在负载均衡问题，需要对定时器分片.
+//  1. 不能持久化回调函数
+//  2. 单个zset保持所有定时器，如果定时器过多，存在负载均衡问题，已通过分片解决，见 shardFor.
 type Manager struct {
 	name    string        // 全局唯一
 	redis   *redis.Client // redis客户端
 	notifys chan *Timer   // 超时的定时器
+
+	mu         sync.RWMutex
+	shardCount int           // 分片数量
+	stop       chan struct{} // 关闭当前一批background goroutine，Rebalance时会换新的
+
+	election ElectionBackend // 可选的leader选举后端，nil表示单副本模式，始终是leader
+	cancel   context.CancelFunc
+
+	registry *registry // 可选的HandlerRegistry能力，见 RegisterHandler
+	codec    Codec     // AddTimerPayload/GetTypedNotifys使用的编解码器，默认JSONCodec{}
+}
+
+// NewManager 生成定时器管理器对象，shardCount 为定时器ZSET的分片数，每个
+// 分片对应独立的 BZPopMin 循环，用于把定时器负载分散到多个Redis Cluster slot
+// 上；shardCount <= 0 时按1个分片处理（等价于未分片前的行为）.
+func NewManager(name string, cap int, redis *redis.Client, shardCount int) (*Manager, error) {
+	return newManager(name, cap, redis, shardCount, nil)
 }
 
-// NewManager 生成定时器管理器对象
-func NewManager(name string, cap int, redis *redis.Client) (*Manager, error) {
+// NewManagerWithElection 生成带leader选举的定时器管理器：当多个进程用
+// 相同的 name 启动 Manager 时，只有选举为leader的那个实例才会真正消费和
+// 投递定时器，其余实例保持运行但处于待命状态，在leader租约失效后接管.
+func NewManagerWithElection(name string, cap int, redis *redis.Client, shardCount int, backend ElectionBackend) (*Manager, error) {
+	if backend == nil {
+		return nil, fmt.Errorf("election backend is required")
+	}
+	return newManager(name, cap, redis, shardCount, backend)
+}
 
+func newManager(name string, cap int, redis *redis.Client, shardCount int, backend ElectionBackend) (*Manager, error) {
+	if shardCount <= 0 {
+		shardCount = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
 	mgr := &Manager{
-		name:    name,
-		redis:   redis,
-		notifys: make(chan *Timer, cap),
+		name:       name,
+		redis:      redis,
+		notifys:    make(chan *Timer, cap),
+		shardCount: shardCount,
+		stop:       make(chan struct{}),
+		election:   backend,
+		cancel:     cancel,
+		registry:   newRegistry(),
+		codec:      JSONCodec{},
+	}
+
+	if backend != nil {
+		go backend.Campaign(ctx)
 	}
 
-	go mgr.background()
+	mgr.startBackground(shardCount, mgr.stop)
 
 	return mgr, nil
 }
 
+// LeaderCh 返回leader状态变化通知：true表示当选，false表示
+// 失去leader身份。未启用选举时返回nil（始终是leader，不会有状态变化）.
+func (mgr *Manager) LeaderCh() <-chan bool {
+	if mgr.election == nil {
+		return nil
+	}
+	return mgr.election.Leader()
+}
+
+// isLeader 未启用选举时始终视为leader，保持单副本下的原有行为.
+func (mgr *Manager) isLeader() bool {
+	return mgr.election == nil || mgr.election.IsLeader()
+}
+
+// Close 停止选举参与和所有background goroutine.
+func (mgr *Manager) Close() error {
+	if mgr.cancel != nil {
+		mgr.cancel()
+	}
+	mgr.mu.Lock()
+	close(mgr.stop)
+	mgr.mu.Unlock()
+
+	close(mgr.registry.reaperStop)
+
+	if mgr.election != nil {
+		return mgr.election.Close()
+	}
+	return nil
+}
+
+// startBackground 为 [0, shardCount) 的每个分片启动一个独立的轮询goroutine.
+func (mgr *Manager) startBackground(shardCount int, stop chan struct{}) {
+	for shard := 0; shard < shardCount; shard++ {
+		go mgr.background(shard, stop)
+	}
+}
+
 // GetNotifys 获取定时通知管道
 func (mgr *Manager) GetNotifys() <-chan *Timer {
 	return mgr.notifys
 }
 
-func (mgr *Manager) background() {
+func (mgr *Manager) background(shard int, stop <-chan struct{}) {
+	key := mgr.ShardKey(shard)
 	for {
-		result, err := mgr.redis.BZPopMin(time.Minute, mgr.name).Result()
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		if !mgr.isLeader() {
+			// 未当选leader：保持待命，不消费定时器，避免多副本重复触发
+			time.Sleep(time.Second)
+			continue
+		}
+
+		result, err := mgr.redis.BZPopMin(time.Minute, key).Result()
 		if err != nil {
 			if err != redis.Nil { // not timeout?
-				zap.L().Warn(fmt.Sprintf("failed to bzpopmin %s: %s", mgr.name, err))
+				zap.L().Warn(fmt.Sprintf("failed to bzpopmin %s: %s", key, err))
 				time.Sleep(3 * time.Second)
 			}
 			continue
 		}
-		id, ok := result.Member.(string)
+		member, ok := result.Member.(string)
 		if !ok {
-			zap.L().Warn(fmt.Sprintf("failed to bzpopmin %s: member type is %T", mgr.name, result.Member))
+			zap.L().Warn(fmt.Sprintf("failed to bzpopmin %s: member type is %T", key, result.Member))
 			continue
 		}
 
 		deadline := int64(result.Score)
 		if delta := deadline - time.Now().Unix(); delta > 0 { // 未到期?
 			// 重新设置回去，并休眠等待
-			mgr.redis.ZAdd(mgr.name, redis.Z{Member: id, Score: float64(deadline)})
+			mgr.redis.ZAdd(key, redis.Z{Member: member, Score: float64(deadline)})
 			time.Sleep(time.Duration(delta) * time.Second)
 			continue
 		}
 
+		// AddTimerPayload 写入的定时器把payload内联编码进了member里，
+		// 不需要再GET一次ContextKey就能拿到完整数据.
+		if id, inlined, ok := splitInlineMember(member); ok {
+			mgr.redis.HDel(mgr.memberIndexKey(shard), id)
+			t := &Timer{ID: id, Payload: inlined, Deadline: time.Unix(deadline, 0)}
+			select {
+			case mgr.notifys <- t:
+				// NOOP
+			case <-time.After(3 * time.Second):
+				zap.L().Error(fmt.Sprintf("failed to put into timer: notify channel overflow"))
+			}
+			continue
+		}
+		id := member
+
 		// 发生超时
-		ctx, err := mgr.redis.Get(mgr.ContextKey(id)).Result()
+		ctx, err := mgr.redis.Get(mgr.ContextKey(shard, id)).Result()
 		if err != nil {
 			zap.L().Warn(fmt.Sprintf("failed to get timer context: %s", err))
 			continue
 		}
+		handlerName, err := mgr.redis.Get(mgr.HandlerKey(shard, id)).Result()
+		if err != nil && err != redis.Nil {
+			zap.L().Warn(fmt.Sprintf("failed to get timer handler: %s", err))
+		}
 		t := &Timer{
-			ID:       id,
-			Ctx:      ctx,
-			Deadline: time.Unix(deadline, 0),
+			ID:          id,
+			Ctx:         ctx,
+			HandlerName: handlerName,
+			Deadline:    time.Unix(deadline, 0),
+		}
+
+		if spec, ok := mgr.getSchedule(shard, id); ok {
+			if next, err := spec.Next(t.Deadline); err != nil {
+				zap.L().Warn(fmt.Sprintf("failed to compute next deadline for %s: %s", id, err))
+			} else if err := mgr.rescheduleRecurring(shard, id, []byte(ctx), next); err != nil {
+				zap.L().Warn(fmt.Sprintf("%s", err))
+			}
+		}
+
+		if t.HandlerName != "" {
+			mgr.dispatch(shard, t)
+			continue
 		}
-		// ok
+
 		select {
 		case mgr.notifys <- t:
 			// NOOP
@@ -91,6 +220,12 @@ func (mgr *Manager) background() {
 
 // AddTimer 增加定时器
 func (mgr *Manager) AddTimer(t *Timer) error {
+	if err := validateTimerID(t.ID); err != nil {
+		return err
+	}
+
+	shard := mgr.shardFor(t.ID)
+
 	pipe := mgr.redis.Pipeline()
 	defer pipe.Close()
 
@@ -99,8 +234,13 @@ func (mgr *Manager) AddTimer(t *Timer) error {
 	if now := time.Now(); t.Deadline.After(now) {
 		exp += t.Deadline.Sub(now)
 	}
-	pipe.Set(mgr.ContextKey(t.ID), t.Ctx, exp)
-	pipe.ZAdd(mgr.name, redis.Z{
+	pipe.Set(mgr.ContextKey(shard, t.ID), t.Ctx, exp)
+	if t.HandlerName != "" {
+		pipe.Set(mgr.HandlerKey(shard, t.ID), t.HandlerName, exp)
+	} else {
+		pipe.Del(mgr.HandlerKey(shard, t.ID))
+	}
+	pipe.ZAdd(mgr.ShardKey(shard), redis.Z{
 		Member: t.ID,
 		Score:  float64(t.Deadline.Unix()),
 	})
@@ -114,11 +254,17 @@ func (mgr *Manager) AddTimer(t *Timer) error {
 // DelTimer 删除定时器
 // 注意：当收到定时器通知事件时，该定时器已经pop出来，不需要再显示删除;
 func (mgr *Manager) DelTimer(id string) error {
+	shard := mgr.shardFor(id)
+	member := mgr.resolveMember(shard, id) // AddTimerPayload写入的定时器member不等于id，需要先还原
+
 	pipe := mgr.redis.Pipeline()
 	defer pipe.Close()
 
-	pipe.ZRem(mgr.name, id)
-	pipe.Del(mgr.ContextKey(id))
+	pipe.ZRem(mgr.ShardKey(shard), member)
+	pipe.HDel(mgr.memberIndexKey(shard), id)
+	pipe.Del(mgr.ContextKey(shard, id))
+	pipe.Del(mgr.HandlerKey(shard, id))
+	pipe.Del(mgr.scheduleKey(shard, id))
 	if _, err := pipe.Exec(); err != nil {
 		return fmt.Errorf("failed to del timer: %s", err)
 	}
@@ -126,7 +272,190 @@ func (mgr *Manager) DelTimer(id string) error {
 	return nil
 }
 
+// ShardKey 分片对应的ZSET键名，使用 `{shard}` hash-tag，保证同一分片的
+// ZSET和其下所有定时器的上下文键都落在同一个Redis Cluster slot，从而可以
+// 在同一个MULTI/EXEC管道中操作.
+func (mgr *Manager) ShardKey(shard int) string {
+	return fmt.Sprintf("%s:{%d}", mgr.name, shard)
+}
+
 // ContextKey 定时器上下文键名
-func (mgr *Manager) ContextKey(id string) string {
-	return fmt.Sprintf("%s_%s", mgr.name, id)
+func (mgr *Manager) ContextKey(shard int, id string) string {
+	return fmt.Sprintf("%s_%s", mgr.ShardKey(shard), id)
+}
+
+// HandlerKey 定时器绑定的HandlerRegistry回调名键名，独立于ContextKey存
+// 储：Ctx是调用方自由格式的业务数据（很可能本身就是JSON），如果和
+// HandlerName编码进同一个值里再靠"能不能JSON解析"去猜测格式，对于恰好是
+// 合法JSON的Ctx会被错误地判定成新格式、导致Ctx被截断丢失，所以两者分开存.
+func (mgr *Manager) HandlerKey(shard int, id string) string {
+	return fmt.Sprintf("%s_handler_%s", mgr.ShardKey(shard), id)
+}
+
+// shardFor 通过FNV-1a哈希对分片数取模，把定时器ID映射到某个分片上：是
+// 普通的hash%shardCount，不是一致性哈希环，所以 shardCount 一变，绝大多数
+// id映射到的分片都会变，而不是一致性哈希环理论上只搬一小部分。Rebalance
+// 因此按"全量扫描旧分片、搬走映射变化的条目"实现，代价接近重写所有分片.
+func (mgr *Manager) shardFor(id string) int {
+	mgr.mu.RLock()
+	shardCount := mgr.shardCount
+	mgr.mu.RUnlock()
+	return shardHash(id) % shardCount
+}
+
+func shardHash(id string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(id))
+	return int(h.Sum32())
+}
+
+// Rebalance 调整分片数量，并把已有定时器从旧分片迁移到新分片对应的ZSET和
+// 上下文键上，迁移完成后重启所有background goroutine使其按新的分片数工作。
+// 因为 shardFor 用的是普通取模而不是一致性哈希环，这里是对每个旧分片的
+// 全量扫描+按需搬家，而不是只搬一小部分，分片数越大、定时器越多开销越大.
+// 迁移期间旧的background goroutine仍在运行，每个定时器要么在旧分片里被
+// 处理，要么被搬到新分片里等待处理，不会丢失.
+func (mgr *Manager) Rebalance(newShardCount int) error {
+	if newShardCount <= 0 {
+		return fmt.Errorf("invalid shard count: %d", newShardCount)
+	}
+
+	mgr.mu.RLock()
+	oldShardCount := mgr.shardCount
+	mgr.mu.RUnlock()
+
+	for shard := 0; shard < oldShardCount; shard++ {
+		if err := mgr.migrateShard(shard, oldShardCount, newShardCount); err != nil {
+			return fmt.Errorf("failed to migrate shard %d: %s", shard, err)
+		}
+	}
+
+	mgr.mu.Lock()
+	close(mgr.stop)
+	mgr.shardCount = newShardCount
+	mgr.stop = make(chan struct{})
+	stop := mgr.stop
+	mgr.mu.Unlock()
+
+	mgr.startBackground(newShardCount, stop)
+
+	return nil
+}
+
+// migrateShard 把单个旧分片里、在新分片数下应当落到别处的定时器搬家，包括
+// AddCron 写入的scheduleKey：不搬的话循环定时器下一次 getSchedule(newShard,
+// id) 会找不到规则，静默退化成一次性定时器。ZSET的member既可能是
+// AddTimer/AddCron 写入的裸id，也可能是 AddTimerPayload 写入的
+// `id|base64(payload)` 内联member，两种要按各自的真实id计算目标分片、搬
+// 各自的配套键，否则内联payload定时器会按整个复合字符串错误哈希，搬家后
+// memberIndexKey 仍指向旧分片，DelTimer 会找错member而失效.
+func (mgr *Manager) migrateShard(shard, oldShardCount, newShardCount int) error {
+	members, err := mgr.redis.ZRangeWithScores(mgr.ShardKey(shard), 0, -1).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil
+		}
+		return err
+	}
+
+	for _, m := range members {
+		member, ok := m.Member.(string)
+		if !ok {
+			continue
+		}
+
+		if id, _, ok := splitInlineMember(member); ok {
+			if err := mgr.migrateInlinePayloadMember(shard, newShardCount, id, member, m.Score); err != nil {
+				zap.L().Warn(fmt.Sprintf("failed to migrate inline payload timer %s: %s", id, err))
+			}
+			continue
+		}
+
+		id := member
+		newShard := shardHash(id) % newShardCount
+		if newShard == shard {
+			continue
+		}
+
+		oldCtxKey := mgr.ContextKey(shard, id)
+		ctx, ttl, err := mgr.getContextWithTTL(oldCtxKey)
+		if err != nil {
+			zap.L().Warn(fmt.Sprintf("failed to read context for rebalance %s: %s", id, err))
+			continue
+		}
+		oldHandlerKey := mgr.HandlerKey(shard, id)
+		handlerName, hasHandler, err := mgr.getOptional(oldHandlerKey)
+		if err != nil {
+			zap.L().Warn(fmt.Sprintf("failed to read handler for rebalance %s: %s", id, err))
+			continue
+		}
+		oldScheduleKey := mgr.scheduleKey(shard, id)
+		schedRaw, hasSchedule, err := mgr.getOptional(oldScheduleKey)
+		if err != nil {
+			zap.L().Warn(fmt.Sprintf("failed to read schedule for rebalance %s: %s", id, err))
+			continue
+		}
+
+		pipe := mgr.redis.Pipeline()
+		pipe.ZRem(mgr.ShardKey(shard), id)
+		pipe.Del(oldCtxKey)
+		pipe.Del(oldHandlerKey)
+		pipe.Del(oldScheduleKey)
+		pipe.ZAdd(mgr.ShardKey(newShard), redis.Z{Member: id, Score: m.Score})
+		pipe.Set(mgr.ContextKey(newShard, id), ctx, ttl)
+		if hasHandler {
+			pipe.Set(mgr.HandlerKey(newShard, id), handlerName, ttl)
+		}
+		if hasSchedule {
+			pipe.Set(mgr.scheduleKey(newShard, id), schedRaw, 0)
+		}
+		if _, err := pipe.Exec(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateInlinePayloadMember 搬家 AddTimerPayload 写入的内联payload定时器：
+// 按真实id（而不是整个 `id|base64(...)` member）计算目标分片，并把
+// memberIndexKey 里 id 到member的映射也一并搬过去，否则 DelTimer 会在新
+// 分片里查不到映射、退化成用id本身当member做无效 ZRem.
+func (mgr *Manager) migrateInlinePayloadMember(shard, newShardCount int, id, member string, score float64) error {
+	newShard := shardHash(id) % newShardCount
+	if newShard == shard {
+		return nil
+	}
+
+	pipe := mgr.redis.Pipeline()
+	pipe.ZRem(mgr.ShardKey(shard), member)
+	pipe.HDel(mgr.memberIndexKey(shard), id)
+	pipe.ZAdd(mgr.ShardKey(newShard), redis.Z{Member: member, Score: score})
+	pipe.HSet(mgr.memberIndexKey(newShard), id, member)
+	_, err := pipe.Exec()
+	return err
+}
+
+func (mgr *Manager) getContextWithTTL(key string) (string, time.Duration, error) {
+	ctx, err := mgr.redis.Get(key).Result()
+	if err != nil {
+		return "", 0, err
+	}
+	ttl, err := mgr.redis.TTL(key).Result()
+	if err != nil {
+		return "", 0, err
+	}
+	return ctx, ttl, nil
+}
+
+// getOptional 读取一个可能不存在的key，key不存在时返回 ok=false 而不是error.
+func (mgr *Manager) getOptional(key string) (value string, ok bool, err error) {
+	value, err = mgr.redis.Get(key).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return value, true, nil
 }
@@ -0,0 +1,43 @@
+package persistimer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func Test_timer_rebalance(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr:         "127.0.0.1:6379",
+		Password:     "",
+		ReadTimeout:  1 * time.Second,
+		WriteTimeout: 1 * time.Second,
+		DialTimeout:  1 * time.Second,
+		PoolSize:     16,
+		MaxRetries:   3,
+	})
+
+	mgr, err := NewManager("timer_rebalance", 128, client, 4)
+	if err != nil {
+		t.Fatalf("failed to new timer manager: %s", err)
+	}
+
+	if err := mgr.AddTimer(&Timer{ID: "rebalance-1", Ctx: "ctx-rebalance", Deadline: time.Now().Add(2 * time.Second)}); err != nil {
+		t.Fatalf("failed to add timer: %s", err)
+	}
+
+	// 把分片数从4改成8，定时器应当被原样搬到新的分片下，触发时还是能收到.
+	if err := mgr.Rebalance(8); err != nil {
+		t.Fatalf("failed to rebalance: %s", err)
+	}
+
+	select {
+	case timer := <-mgr.GetNotifys():
+		if timer.ID != "rebalance-1" || timer.Ctx != "ctx-rebalance" {
+			t.Fatalf("wrong timer after rebalance: %+v", timer)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timer did not fire after rebalance")
+	}
+}
@@ -18,7 +18,7 @@ func Test_timer(t *testing.T) {
 		MaxRetries:   3,
 	})
 
-	mgr, err := NewManager("timer_name", 128, redis)
+	mgr, err := NewManager("timer_name", 128, redis, 4)
 	if err != nil {
 		t.Fatalf("faild to new timer manager: %s", err)
 	}
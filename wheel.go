@@ -0,0 +1,304 @@
+package persistimer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"go.uber.org/zap"
+)
+
+// 时间轮参数：每层512个槽位，最底层每槽10ms.
+// 上层槽位覆盖的时间是下层整圈所覆盖的时间，逐层级联（cascading）.
+const (
+	wheelSlots = 512
+	wheelTick  = 10 * time.Millisecond
+	wheelLevel = 4 // 10ms * 512^4 ≈ 7954天（约21.8年），足够覆盖绝大多数业务场景
+)
+
+// wheelNode 时间轮中的一个定时器节点，通过双向链表挂在某个槽位上，
+// 以便 DelTimer 能够 O(1) 摘除.
+type wheelNode struct {
+	id       string
+	ctx      string
+	deadline int64 // 到期时间，unix毫秒
+	level    int   // 当前所在层级
+	slot     int   // 当前所在槽位
+	prev     *wheelNode
+	next     *wheelNode
+}
+
+// wheelBucket 一个槽位对应的双向链表.
+type wheelBucket struct {
+	head *wheelNode
+	tail *wheelNode
+}
+
+func (b *wheelBucket) pushBack(n *wheelNode) {
+	n.prev, n.next = b.tail, nil
+	if b.tail != nil {
+		b.tail.next = n
+	} else {
+		b.head = n
+	}
+	b.tail = n
+}
+
+func (b *wheelBucket) remove(n *wheelNode) {
+	if n.prev != nil {
+		n.prev.next = n.next
+	} else {
+		b.head = n.next
+	}
+	if n.next != nil {
+		n.next.prev = n.prev
+	} else {
+		b.tail = n.prev
+	}
+	n.prev, n.next = nil, nil
+}
+
+// WheelManager 基于分层时间轮（hierarchical timing wheel）的定时器管理器：
+// 相比 Manager 每次到期都要走一次 BZPopMin，WheelManager 在进程内用时间轮
+// 以毫秒级精度驱动定时器触发，Redis 仅用于持久化、崩溃恢复，日常触发不再
+// 访问 Redis，吞吐和精度都更高.
+// 缺点：
+//  1. 和 Manager 一样不能持久化回调函数;
+//  2. 时间轮状态保存在单进程内存中，不支持多副本部署.
+type WheelManager struct {
+	name    string        // 全局唯一
+	redis   *redis.Client // redis客户端，用于持久化和崩溃恢复
+	notifys chan *Timer   // 超时的定时器
+
+	mu     sync.Mutex
+	levels [wheelLevel][wheelSlots]wheelBucket
+	cursor [wheelLevel]int
+	index  map[string]*wheelNode // id -> node，用于 O(1) 删除
+
+	stop chan struct{}
+}
+
+// NewWheelManager 生成基于时间轮的定时器管理器对象，启动时会从 Redis 的
+// ZSET 中回放（replay）全部定时器到时间轮中，已过期的会在下一个tick触发.
+func NewWheelManager(name string, cap int, redis *redis.Client) (*WheelManager, error) {
+	mgr := &WheelManager{
+		name:    name,
+		redis:   redis,
+		notifys: make(chan *Timer, cap),
+		index:   make(map[string]*wheelNode),
+		stop:    make(chan struct{}),
+	}
+
+	if err := mgr.replay(); err != nil {
+		return nil, fmt.Errorf("failed to replay timers from redis: %s", err)
+	}
+
+	go mgr.background()
+
+	return mgr, nil
+}
+
+// replay 进程启动时把 Redis ZSET 中的全部定时器重新放入时间轮，用于
+// 崩溃恢复：即使进程重启，内存中的时间轮状态丢失，也能从 Redis 补齐.
+func (mgr *WheelManager) replay() error {
+	members, err := mgr.redis.ZRangeWithScores(mgr.name, 0, -1).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil
+		}
+		return err
+	}
+
+	for _, m := range members {
+		id, ok := m.Member.(string)
+		if !ok {
+			zap.L().Warn(fmt.Sprintf("failed to replay %s: member type is %T", mgr.name, m.Member))
+			continue
+		}
+		ctx, err := mgr.redis.Get(mgr.ContextKey(id)).Result()
+		if err != nil && err != redis.Nil {
+			zap.L().Warn(fmt.Sprintf("failed to replay timer context %s: %s", id, err))
+			continue
+		}
+		deadline := time.Unix(int64(m.Score), 0)
+		mgr.schedule(id, ctx, deadline.UnixNano()/int64(time.Millisecond))
+	}
+
+	return nil
+}
+
+// GetNotifys 获取定时通知管道
+func (mgr *WheelManager) GetNotifys() <-chan *Timer {
+	return mgr.notifys
+}
+
+// AddTimer 增加定时器：先写入 Redis 做持久化镜像，再挂入时间轮等待触发.
+func (mgr *WheelManager) AddTimer(t *Timer) error {
+	pipe := mgr.redis.Pipeline()
+	defer pipe.Close()
+
+	exp := time.Minute * 10 // 冗余量
+	if now := time.Now(); t.Deadline.After(now) {
+		exp += t.Deadline.Sub(now)
+	}
+	pipe.Set(mgr.ContextKey(t.ID), t.Ctx, exp)
+	pipe.ZAdd(mgr.name, redis.Z{
+		Member: t.ID,
+		Score:  float64(t.Deadline.Unix()),
+	})
+	if _, err := pipe.Exec(); err != nil {
+		return fmt.Errorf("failed to add timer: %s", err)
+	}
+
+	mgr.schedule(t.ID, t.Ctx, t.Deadline.UnixNano()/int64(time.Millisecond))
+
+	return nil
+}
+
+// DelTimer 删除定时器
+func (mgr *WheelManager) DelTimer(id string) error {
+	pipe := mgr.redis.Pipeline()
+	defer pipe.Close()
+
+	pipe.ZRem(mgr.name, id)
+	pipe.Del(mgr.ContextKey(id))
+	if _, err := pipe.Exec(); err != nil {
+		return fmt.Errorf("failed to del timer: %s", err)
+	}
+
+	mgr.mu.Lock()
+	if n, ok := mgr.index[id]; ok {
+		bucket := mgr.bucketOf(n)
+		bucket.remove(n)
+		delete(mgr.index, id)
+	}
+	mgr.mu.Unlock()
+
+	return nil
+}
+
+// ContextKey 定时器上下文键名
+func (mgr *WheelManager) ContextKey(id string) string {
+	return fmt.Sprintf("%s_%s", mgr.name, id)
+}
+
+// schedule 按到期时间（毫秒）计算所在层级和槽位，挂入时间轮.
+// 内部会加 mgr.mu，调用方不能持有该锁.
+func (mgr *WheelManager) schedule(id, ctx string, deadlineMs int64) {
+	n := &wheelNode{id: id, ctx: ctx, deadline: deadlineMs}
+
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	if old, ok := mgr.index[id]; ok {
+		mgr.bucketOf(old).remove(old)
+	}
+
+	level, slot := mgr.locate(deadlineMs)
+	mgr.levels[level][slot].pushBack(n)
+	mgr.index[id] = n
+	n.level, n.slot = level, slot
+}
+
+// bucketOf 返回节点当前所在的槽位.
+func (mgr *WheelManager) bucketOf(n *wheelNode) *wheelBucket {
+	return &mgr.levels[n.level][n.slot]
+}
+
+// locate 计算到期时间相对当前时间落在哪一层、哪一个槽位.
+// 层级越高，每个槽位覆盖的时间跨度越大（wheelTick * wheelSlots^level）.
+// 注意：advance() 是先推进cursor再drain，也就是说cursor[level]当前指向
+// 的是"刚刚被drain过的槽位"，下一次真正被drain到的槽位是cursor+1；因此
+// ticks要+1，把"到期时间"锚定到下一次drain，而不是刚drain过、要再转一整
+// 圈才会被drain到的槽位，否则到期时间恰好是"现在"的定时器要再等一圈才能触发.
+func (mgr *WheelManager) locate(deadlineMs int64) (level, slot int) {
+	nowMs := time.Now().UnixNano() / int64(time.Millisecond)
+	delta := deadlineMs - nowMs
+	if delta < 0 {
+		delta = 0
+	}
+	ticks := delta/int64(wheelTick/time.Millisecond) + 1
+
+	for level = 0; level < wheelLevel-1; level++ {
+		span := int64(1)
+		for i := 0; i <= level; i++ {
+			span *= wheelSlots
+		}
+		if ticks < span {
+			break
+		}
+	}
+
+	span := int64(1)
+	for i := 0; i < level; i++ {
+		span *= wheelSlots
+	}
+	slot = (mgr.cursor[level] + int(ticks/span)) % wheelSlots
+	return level, slot
+}
+
+// background 每 wheelTick 推进一次最底层时间轮指针，指针走到的槽位里的
+// 定时器要么触发、要么降级（cascade）到更低层级做更精细的调度.
+func (mgr *WheelManager) background() {
+	ticker := time.NewTicker(wheelTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-mgr.stop:
+			return
+		case <-ticker.C:
+			mgr.advance()
+		}
+	}
+}
+
+// advance 推进一个 tick：level0 指针前移一格，触发该槽位内所有到期定时器；
+// 当 level0 走完一圈时，从 level1 取出一格做级联降级，以此类推.
+func (mgr *WheelManager) advance() {
+	mgr.mu.Lock()
+	mgr.cursor[0] = (mgr.cursor[0] + 1) % wheelSlots
+	fired := mgr.drain(0, mgr.cursor[0])
+
+	for level := 0; mgr.cursor[level] == 0 && level+1 < wheelLevel; level++ {
+		mgr.cursor[level+1] = (mgr.cursor[level+1] + 1) % wheelSlots
+		for _, n := range mgr.drain(level+1, mgr.cursor[level+1]) {
+			newLevel, newSlot := mgr.locate(n.deadline)
+			mgr.levels[newLevel][newSlot].pushBack(n)
+			n.level, n.slot = newLevel, newSlot
+			mgr.index[n.id] = n
+		}
+	}
+	mgr.mu.Unlock()
+
+	for _, n := range fired {
+		t := &Timer{ID: n.id, Ctx: n.ctx, Deadline: time.UnixMilli(n.deadline)}
+		select {
+		case mgr.notifys <- t:
+			// NOOP
+		case <-time.After(3 * time.Second):
+			zap.L().Error(fmt.Sprintf("failed to put into timer: notify channel overflow"))
+		}
+	}
+}
+
+// drain 清空某一层某个槽位的全部节点并返回；只有 level 0（即将触发）的
+// 节点会从索引中摘除，更高层的节点留在索引里，由调用方重新挂入时间轮.
+// 调用方需持有 mgr.mu.
+func (mgr *WheelManager) drain(level, slot int) []*wheelNode {
+	bucket := &mgr.levels[level][slot]
+	var nodes []*wheelNode
+	for n := bucket.head; n != nil; {
+		next := n.next
+		nodes = append(nodes, n)
+		n = next
+	}
+	bucket.head, bucket.tail = nil, nil
+	if level == 0 {
+		for _, n := range nodes {
+			delete(mgr.index, n.id)
+		}
+	}
+	return nodes
+}
@@ -0,0 +1,40 @@
+package persistimer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func Test_wheelManager_fireOverdue(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr:         "127.0.0.1:6379",
+		Password:     "",
+		ReadTimeout:  1 * time.Second,
+		WriteTimeout: 1 * time.Second,
+		DialTimeout:  1 * time.Second,
+		PoolSize:     16,
+		MaxRetries:   3,
+	})
+
+	mgr, err := NewWheelManager("wheel_timer_name", 128, client)
+	if err != nil {
+		t.Fatalf("failed to new wheel manager: %s", err)
+	}
+
+	// 到期时间就是现在（甚至已经过期），应当在下一个tick（10ms）内就触发，
+	// 而不是要转满一整圈（512 * 10ms）才触发.
+	if err := mgr.AddTimer(&Timer{ID: "now", Ctx: "ctx-now", Deadline: time.Now()}); err != nil {
+		t.Fatalf("failed to add timer: %s", err)
+	}
+
+	select {
+	case timer := <-mgr.GetNotifys():
+		if timer.ID != "now" {
+			t.Fatalf("wrong timer fired: %s", timer.ID)
+		}
+	case <-time.After(200 * time.Millisecond):
+		t.Fatalf("overdue timer did not fire within 200ms")
+	}
+}